@@ -0,0 +1,80 @@
+package ndt7
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Config groups the settings used to build the websocket.Upgrader shared by
+// the ndt7 subtest handlers.
+type Config struct {
+	// AllowedOrigins lists the origins a browser-based measurement client is
+	// allowed to connect from. Entries may be exact hostnames (e.g.
+	// "ndt.example.com") or glob patterns understood by path.Match (e.g.
+	// "*.measurementlab.net"). Ignored when CheckOrigin is set.
+	AllowedOrigins []string
+
+	// CheckOrigin, when set, is used verbatim as the Upgrader's CheckOrigin
+	// and takes precedence over AllowedOrigins.
+	CheckOrigin func(*http.Request) bool
+}
+
+// NewUpgrader builds the websocket.Upgrader to embed into the ndt7 subtest
+// handlers according to config. Operators that need to accept browser-based
+// measurement clients hosted on known domains should populate
+// config.AllowedOrigins rather than disabling origin checking altogether.
+func NewUpgrader(config Config) websocket.Upgrader {
+	return websocket.Upgrader{
+		CheckOrigin: config.checkOrigin(),
+	}
+}
+
+// checkOrigin returns the CheckOrigin function to use for the Upgrader, or
+// nil to fall back to gorilla/websocket's own same-origin default.
+func (config Config) checkOrigin() func(*http.Request) bool {
+	if config.CheckOrigin != nil {
+		return config.CheckOrigin
+	}
+	if len(config.AllowedOrigins) == 0 {
+		return nil
+	}
+	return func(request *http.Request) bool {
+		origin := request.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		host := parsed.Hostname()
+		for _, pattern := range config.AllowedOrigins {
+			if pattern == host {
+				return true
+			}
+			if ok, err := path.Match(pattern, host); err == nil && ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// hasSecWebSocketProtocol returns true if |header|, the value of the
+// Sec-WebSocket-Protocol request header, lists SecWebSocketProtocol among
+// the (possibly multiple, comma-separated) subprotocols the client is
+// offering. This matches RFC 6455's subprotocol selection semantics, under
+// which a client may advertise several subprotocols and the server picks
+// the one it supports.
+func hasSecWebSocketProtocol(header string) bool {
+	for _, offered := range strings.Split(header, ",") {
+		if strings.TrimSpace(offered) == SecWebSocketProtocol {
+			return true
+		}
+	}
+	return false
+}
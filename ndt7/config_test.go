@@ -0,0 +1,94 @@
+package ndt7
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newRequestWithOrigin(origin string) *http.Request {
+	request := httptest.NewRequest("GET", "/ndt/v7/download", nil)
+	if origin != "" {
+		request.Header.Set("Origin", origin)
+	}
+	return request
+}
+
+func TestHasSecWebSocketProtocol(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{
+			name:   "empty header",
+			header: "",
+			want:   false,
+		},
+		{
+			name:   "exact match",
+			header: SecWebSocketProtocol,
+			want:   true,
+		},
+		{
+			name:   "single unrelated protocol",
+			header: "some-other-protocol",
+			want:   false,
+		},
+		{
+			name:   "ndt7 among several, no spaces",
+			header: "foo," + SecWebSocketProtocol + ",bar",
+			want:   true,
+		},
+		{
+			name:   "ndt7 among several, with spaces",
+			header: "foo, " + SecWebSocketProtocol + ", bar",
+			want:   true,
+		},
+		{
+			name:   "ndt7 last in list",
+			header: "foo, bar, " + SecWebSocketProtocol,
+			want:   true,
+		},
+		{
+			name:   "ndt7 with a trailing suffix, no match",
+			header: "FOO," + SecWebSocketProtocol + "X",
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasSecWebSocketProtocol(tt.header); got != tt.want {
+				t.Errorf("hasSecWebSocketProtocol(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigCheckOrigin(t *testing.T) {
+	config := Config{AllowedOrigins: []string{"ndt.example.com", "*.measurementlab.net"}}
+	check := config.checkOrigin()
+	if check == nil {
+		t.Fatal("checkOrigin() returned nil with AllowedOrigins set")
+	}
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{"no origin header", "", true},
+		{"exact host match", "https://ndt.example.com", true},
+		{"exact host match with port", "https://ndt.example.com:8443", true},
+		{"glob match", "https://mlab1.measurementlab.net", true},
+		{"glob match with port", "https://mlab1.measurementlab.net:443", true},
+		{"unrelated origin", "https://evil.example.org", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newRequestWithOrigin(tt.origin)
+			if got := check(request); got != tt.want {
+				t.Errorf("checkOrigin()(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
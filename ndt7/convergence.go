@@ -0,0 +1,76 @@
+package ndt7
+
+// bwWindowSize is the number of BBR bandwidth samples kept by a bwWindow,
+// i.e. 2 seconds of history at the current MinMeasurementInterval of 250ms.
+const bwWindowSize = 8
+
+// EarlyExitInfo records the outcome of the early_exit=1 bandwidth
+// convergence detector. It is attached to the measurement message on which
+// the detector declared convergence, so that researchers can audit the
+// decision after the fact.
+type EarlyExitInfo struct {
+	// Elapsed is the number of nanoseconds since the start of the subtest
+	// at which convergence was declared.
+	Elapsed int64
+}
+
+// bwWindow is a ring buffer of the most recent bwWindowSize BBR bandwidth
+// samples, used to detect when the bandwidth estimate has converged.
+//
+// Comparing only the immediately preceding sample to the current one (as
+// the previous stoppableAccordingToBW algorithm did) fires spuriously
+// during BBR's ProbeRTT dips and on jittery links; requiring the whole
+// window to be flat and non-increasing is much more robust.
+type bwWindow struct {
+	samples []float64
+}
+
+// Add appends bw to the window, evicting the oldest sample once the window
+// is full.
+func (w *bwWindow) Add(bw float64) {
+	w.samples = append(w.samples, bw)
+	if len(w.samples) > bwWindowSize {
+		w.samples = w.samples[1:]
+	}
+}
+
+// Converged returns true when the window holds at least minSamples values,
+// its max is within 25% of its min, and its linear-regression slope is
+// non-positive, i.e. the bandwidth estimate has stopped growing.
+func (w *bwWindow) Converged(minSamples int) bool {
+	if len(w.samples) < minSamples {
+		return false
+	}
+	min, max := w.samples[0], w.samples[0]
+	for _, s := range w.samples {
+		if s < min {
+			min = s
+		}
+		if s > max {
+			max = s
+		}
+	}
+	if min <= 0 || (max-min) >= 0.25*min {
+		return false
+	}
+	return w.slope() <= 0
+}
+
+// slope returns the linear-regression slope of the window's samples
+// against their position in the window.
+func (w *bwWindow) slope() float64 {
+	n := float64(len(w.samples))
+	var sumX, sumY, sumXY, sumXX float64
+	for i, y := range w.samples {
+		x := float64(i)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denom
+}
@@ -0,0 +1,66 @@
+package ndt7
+
+import "testing"
+
+func TestBWWindowConverged(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []float64
+		want    bool
+	}{
+		{
+			name:    "not enough samples yet",
+			samples: []float64{1, 2, 3},
+			want:    false,
+		},
+		{
+			name:    "still growing",
+			samples: []float64{1, 2, 3, 4, 5, 6, 7, 8},
+			want:    false,
+		},
+		{
+			name:    "flat bandwidth",
+			samples: []float64{100, 100, 100, 100, 100, 100, 100, 100},
+			want:    true,
+		},
+		{
+			name:    "flat within 25% tolerance",
+			samples: []float64{100, 90, 110, 95, 105, 100, 90, 100},
+			want:    true,
+		},
+		{
+			name:    "jittery ProbeRTT-like dip, still within tolerance and non-increasing",
+			samples: []float64{100, 98, 90, 99, 97, 95, 96, 94},
+			want:    true,
+		},
+		{
+			name:    "spread exceeds 25% tolerance",
+			samples: []float64{100, 100, 100, 100, 100, 100, 100, 200},
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w bwWindow
+			for _, s := range tt.samples {
+				w.Add(s)
+			}
+			if got := w.Converged(bwWindowSize); got != tt.want {
+				t.Errorf("Converged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBWWindowAddEvictsOldestSample(t *testing.T) {
+	var w bwWindow
+	for i := 0; i < bwWindowSize+2; i++ {
+		w.Add(float64(i))
+	}
+	if len(w.samples) != bwWindowSize {
+		t.Fatalf("len(w.samples) = %d, want %d", len(w.samples), bwWindowSize)
+	}
+	if w.samples[0] != 2 {
+		t.Errorf("oldest remaining sample = %v, want 2 (two samples evicted)", w.samples[0])
+	}
+}
@@ -0,0 +1,111 @@
+package ndt7
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// resultHeader is the first line written to a subtest's result file. It
+// records the negotiated parameters for the subtest -- i.e. duration, bytes
+// and early_exit after server-side clamping -- alongside the UUID and
+// client IP that tag every subsequent resultRecord, so that a reader can
+// reconstruct what was actually requested without re-parsing the original
+// query string.
+type resultHeader struct {
+	UUID      string
+	ClientIP  string
+	Subtest   string
+	Duration  time.Duration
+	Bytes     int64
+	EarlyExit bool
+}
+
+// resultRecord is one measurement line of a subtest's JSON-lines result
+// file, following the resultHeader.
+type resultRecord struct {
+	UUID     string
+	ClientIP string
+	Subtest  string
+	Time     time.Time
+	Measurement
+}
+
+// resultWriter appends one JSON object per line to a per-subtest file under
+// a configurable datadir. A nil *resultWriter is valid and simply discards
+// writes, so that callers don't need to special-case an unset Datadir.
+type resultWriter struct {
+	file *os.File
+	enc  *json.Encoder
+}
+
+// newResultWriter creates the file that will hold the JSON-lines result of
+// a single subtest identified by uuid, writes its resultHeader, and returns
+// a nil *resultWriter when datadir is empty.
+func newResultWriter(datadir, subtest, uuid, clientIP string, params subtestParams) (*resultWriter, error) {
+	if datadir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(datadir, 0755); err != nil {
+		return nil, err
+	}
+	name := filepath.Join(datadir, fmt.Sprintf("%s-%s.jsonl", uuid, subtest))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &resultWriter{file: file, enc: json.NewEncoder(file)}
+	header := resultHeader{
+		UUID:      uuid,
+		ClientIP:  clientIP,
+		Subtest:   subtest,
+		Duration:  params.duration,
+		Bytes:     params.bytesBudget,
+		EarlyExit: params.earlyExit,
+	}
+	if err := w.enc.Encode(&header); err != nil {
+		log.WithError(err).Warn("Cannot write result header")
+	}
+	return w, nil
+}
+
+// WriteMeasurement appends measurement, tagged with the subtest's uuid,
+// name and the client's IP address, as one JSON-lines record.
+func (w *resultWriter) WriteMeasurement(uuid, subtest, clientIP string, measurement Measurement) {
+	if w == nil {
+		return
+	}
+	record := resultRecord{
+		UUID:        uuid,
+		ClientIP:    clientIP,
+		Subtest:     subtest,
+		Time:        time.Now(),
+		Measurement: measurement,
+	}
+	if err := w.enc.Encode(&record); err != nil {
+		log.WithError(err).Warn("Cannot write result record")
+	}
+}
+
+// Close closes the underlying file, if any.
+func (w *resultWriter) Close() {
+	if w == nil {
+		return
+	}
+	w.file.Close()
+}
+
+// clientIP extracts the client's IP address from a RemoteAddr string of the
+// form "host:port", falling back to the whole string if that fails.
+func clientIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
@@ -0,0 +1,24 @@
+package ndt7
+
+// Measurement is the JSON message exchanged on the MinMeasurementInterval
+// ticker between the server and the client during a subtest.
+type Measurement struct {
+	// Elapsed is the number of nanoseconds elapsed since the subtest began.
+	Elapsed int64
+
+	// NumBytes is the number of bytes sent (download) or received (upload)
+	// so far.
+	NumBytes int64
+
+	// TCPInfo carries the kernel's tcp_info sample taken at Elapsed, when
+	// available.
+	TCPInfo *TCPInfo `json:",omitempty"`
+
+	// BBRInfo carries the kernel's BBR congestion-control state sampled at
+	// Elapsed, when available.
+	BBRInfo *BBRInfo `json:",omitempty"`
+
+	// EarlyExit is set on the measurement that triggered the early_exit=1
+	// bandwidth convergence detector.
+	EarlyExit *EarlyExitInfo `json:",omitempty"`
+}
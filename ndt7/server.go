@@ -3,12 +3,10 @@ package ndt7
 import (
 	"crypto/rand"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/apex/log"
 	"github.com/gorilla/websocket"
-	"github.com/m-lab/ndt-cloud/bbr"
 )
 
 // defaultDuration is the default duration of a subtest in nanoseconds.
@@ -17,62 +15,82 @@ const defaultDuration = 10 * time.Second
 // maxDuration is the maximum duration of a subtest in seconds
 const maxDuration = 30
 
+// maxBytes is the maximum value accepted for the bytes query parameter, i.e.
+// the maximum number of bytes a client may request for a single subtest.
+const maxBytes = 8 << 30 // 8 GiB
+
+// Limits bounds the resources a subtest may consume on the server, enforced
+// independently of whatever the client requests. They exist so that a stuck
+// or slow client cannot keep a goroutine and file descriptor alive past the
+// operator's ceiling.
+type Limits struct {
+	// MaxDuration is the hard ceiling on how long a subtest may run,
+	// regardless of the client-supplied duration.
+	MaxDuration time.Duration
+
+	// MaxMessageSize is the maximum size, in bytes, of a WebSocket message
+	// the server is willing to read from the client.
+	MaxMessageSize int64
+
+	// MaxBytes caps the bytes budget of a subtest, regardless of the
+	// client-supplied bytes value. The budget is checked before each
+	// message is sent or read, so the message that crosses it is still
+	// transferred whole and the total may exceed MaxBytes by less than
+	// one message.
+	MaxBytes int64
+}
+
+// defaultLimits are the Limits used for any field left at its zero value.
+var defaultLimits = Limits{
+	MaxDuration:    maxDuration * time.Second,
+	MaxMessageSize: MinMaxMessageSize,
+	MaxBytes:       maxBytes,
+}
+
+// applyDefaultLimits returns limits with defaultLimits substituted in for
+// any field left at its zero value.
+func applyDefaultLimits(limits Limits) Limits {
+	if limits.MaxDuration <= 0 {
+		limits.MaxDuration = defaultLimits.MaxDuration
+	}
+	if limits.MaxMessageSize <= 0 {
+		limits.MaxMessageSize = defaultLimits.MaxMessageSize
+	}
+	if limits.MaxBytes <= 0 {
+		limits.MaxBytes = defaultLimits.MaxBytes
+	}
+	return limits
+}
+
 // DownloadHandler handles a download subtest from the server side.
 type DownloadHandler struct {
 	Upgrader websocket.Upgrader
-}
 
-// stoppableAccordingToBW returns true when we can stop the current download
-// test based on |prev|, the previous BBR bandwidth sample, and |cur| the
-// current BBR bandwidth sample. This algorithm runs every 0.25 seconds and
-// indicates that the download can stop if the bandwidth estimated using
-// BBR stops growing. We use the same percentage used by the BBR paper
-// to characterize the bandwidth growth, i.e. 25%. The BBR paper can be
-// read online at <https://queue.acm.org/detail.cfm?id=3022184>.
-func stoppableAccordingToBW(prev float64, cur float64) bool {
-	return cur >= prev && (cur - prev) < (0.25 * prev)
+	// Limits bounds the resources this handler's subtests may consume on
+	// the server. The zero value means defaultLimits is used.
+	Limits Limits
+
+	// Datadir, when non-empty, is the directory in which a JSON-lines file
+	// recording every Measurement sent during a subtest is written, named
+	// after the subtest's UUID. An empty Datadir disables this archival.
+	Datadir string
 }
 
-// Handle handles the download subtest.
+// ServeHTTP handles the download subtest.
 func (dl DownloadHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	log.Debug("Processing query string")
-	duration := defaultDuration
-	{
-		s := request.URL.Query().Get("duration")
-		if s != "" {
-			value, err := strconv.Atoi(s)
-			if err != nil || value < 0 || value > maxDuration {
-				log.Warn("The duration option has an invalid value")
-				writer.Header().Set("Connection", "Close")
-				writer.WriteHeader(http.StatusBadRequest)
-				return
-			}
-			duration = time.Second * time.Duration(value)
-		}
-	}
-	log.Debug("Upgrading to WebSockets")
-	if request.Header.Get("Sec-WebSocket-Protocol") != SecWebSocketProtocol {
-		log.Warn("Missing Sec-WebSocket-Protocol in request")
-		writer.Header().Set("Connection", "Close")
-		writer.WriteHeader(http.StatusBadRequest)
+	limits := applyDefaultLimits(dl.Limits)
+	params, ok := parseSubtestParams(writer, request, limits)
+	if !ok {
 		return
 	}
-	headers := http.Header{}
-	headers.Add("Sec-WebSocket-Protocol", SecWebSocketProtocol)
-	conn, err := dl.Upgrader.Upgrade(writer, request, headers)
+	log.Debug("Upgrading to WebSockets")
+	conn, err := upgrade(writer, request, dl.Upgrader)
 	if err != nil {
-		log.WithError(err).Warn("upgrader.Upgrade() failed")
+		if err != errMissingSubprotocol {
+			log.WithError(err).Warn("upgrader.Upgrade() failed")
+		}
 		return
 	}
-	// TODO(bassosimone): currently we're leaking filedesc cache entries if we
-	// error out before this point. Because we have concluded that the cache
-	// cannot grow indefinitely, this is probably not a priority.
-	//
-	// We don't care much about an error here because fd is -1 on error and we
-	// will check later whether |fd| is different from that value.
-	fd, _ := bbr.ExtractBBRFd(conn.LocalAddr().String())
-	conn.SetReadLimit(MinMaxMessageSize)
-	defer conn.Close()
 	log.Debug("Generating random buffer")
 	const bufferSize = 1 << 13
 	data := make([]byte, bufferSize)
@@ -80,61 +98,15 @@ func (dl DownloadHandler) ServeHTTP(writer http.ResponseWriter, request *http.Re
 	buffer, err := websocket.NewPreparedMessage(websocket.BinaryMessage, data)
 	if err != nil {
 		log.WithError(err).Warn("websocket.NewPreparedMessage() failed")
+		conn.Close()
 		return
 	}
-	log.Debug("Start sending data to client")
-	ticker := time.NewTicker(MinMeasurementInterval)
-	defer ticker.Stop()
-	t0 := time.Now()
-	count := int64(0)
-	bandwidth := float64(0)
-	for running := true; running; {
-		select {
-		case t := <-ticker.C:
-			// TODO(bassosimone): here we should also include tcp_info data
-			// TODO(bassosimone): here we should also include BBR data
-			measurement := Measurement{
-				Elapsed:  t.Sub(t0).Nanoseconds(),
-				NumBytes: count,
-			}
-			if fd != -1 {
-				bw, rtt, err := bbr.GetBBRInfo(fd)
-				if err == nil {
-					// Implementation note: the linux kernel header seems to suggest
-					// the measurement unit of the RTT is nsec, however, both empirical
-					// evidence and github.com/mikioh/tcpinfo [1] suggest that the RTT
-					// is actually in microseconds.
-					//
-					// [1] See https://github.com/mikioh/tcpinfo/blob/131b59fef27f73876a7760a644c1e08cf585075c/sys_linux.go#L313
-					log.Infof("BW: %f bytes/s; RTT: %f usec", bw, rtt)
-					// TODO(bassosimone): This algorithm is currently enabled by
-					// default by we should actually make it conditional.
-					running = !stoppableAccordingToBW(bandwidth, bw)
-					if !running {
-						log.Info("It seems bandwidth has stopped growing")
-					}
-					bandwidth = bw
-				}
-			}
-			conn.SetWriteDeadline(time.Now().Add(defaultTimeout))
-			if err := conn.WriteJSON(&measurement); err != nil {
-				log.WithError(err).Warn("Cannot send measurement message")
-				return
-			}
-		default: // Not ticking, just send more data
-			if time.Now().Sub(t0) >= duration {
-				running = false
-				break
-			}
-			conn.SetWriteDeadline(time.Now().Add(defaultTimeout))
-			if err := conn.WritePreparedMessage(buffer); err != nil {
-				log.WithError(err).Warn("cannot send data message")
-				return
-			}
-			count += bufferSize
+	step := func(conn *websocket.Conn) (int64, error) {
+		conn.SetWriteDeadline(time.Now().Add(defaultTimeout))
+		if err := conn.WritePreparedMessage(buffer); err != nil {
+			return 0, err
 		}
+		return bufferSize, nil
 	}
-	log.Debug("Closing the WebSocket connection")
-	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(
-		websocket.CloseNormalClosure, ""), time.Now().Add(defaultTimeout))
+	runSubtest(request, conn, limits, dl.Datadir, "download", params, step)
 }
@@ -0,0 +1,206 @@
+package ndt7
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/ndt-cloud/bbr"
+)
+
+// errMissingSubprotocol is returned by upgrade when the client did not
+// offer the ndt7 WebSocket subprotocol. The caller has already written the
+// 400 response by the time this is returned, so it should not be logged.
+var errMissingSubprotocol = errors.New("ndt7: missing Sec-WebSocket-Protocol")
+
+// subtestParams are the query-string-derived parameters common to the
+// download and the upload subtest.
+type subtestParams struct {
+	duration    time.Duration
+	bytesBudget int64
+	earlyExit   bool
+}
+
+// parseSubtestParams parses the duration, bytes and early_exit query
+// parameters shared by the download and the upload subtest. On invalid
+// input it writes a 400 response itself and returns ok=false.
+func parseSubtestParams(writer http.ResponseWriter, request *http.Request, limits Limits) (params subtestParams, ok bool) {
+	params.duration = defaultDuration
+	{
+		s := request.URL.Query().Get("duration")
+		if s != "" {
+			value, err := strconv.Atoi(s)
+			if err != nil || value < 0 || value > maxDuration {
+				log.Warn("The duration option has an invalid value")
+				writer.Header().Set("Connection", "Close")
+				writer.WriteHeader(http.StatusBadRequest)
+				return subtestParams{}, false
+			}
+			params.duration = time.Second * time.Duration(value)
+		}
+	}
+	params.bytesBudget = int64(math.MaxInt64)
+	{
+		s := request.URL.Query().Get("bytes")
+		if s != "" {
+			value, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || value < 0 || value > maxBytes {
+				log.Warn("The bytes option has an invalid value")
+				writer.Header().Set("Connection", "Close")
+				writer.WriteHeader(http.StatusBadRequest)
+				return subtestParams{}, false
+			}
+			params.bytesBudget = value
+		}
+	}
+	if params.bytesBudget > limits.MaxBytes {
+		params.bytesBudget = limits.MaxBytes
+	}
+	params.earlyExit = request.URL.Query().Get("early_exit") == "1"
+	return params, true
+}
+
+// upgrade negotiates the ndt7 WebSocket subprotocol and hands off to
+// upgrader.Upgrade. On a missing subprotocol it writes a 400 response
+// itself and returns errMissingSubprotocol.
+func upgrade(writer http.ResponseWriter, request *http.Request, upgrader websocket.Upgrader) (*websocket.Conn, error) {
+	if !hasSecWebSocketProtocol(request.Header.Get("Sec-WebSocket-Protocol")) {
+		log.Warn("Missing Sec-WebSocket-Protocol in request")
+		writer.Header().Set("Connection", "Close")
+		writer.WriteHeader(http.StatusBadRequest)
+		return nil, errMissingSubprotocol
+	}
+	headers := http.Header{}
+	headers.Add("Sec-WebSocket-Protocol", SecWebSocketProtocol)
+	return upgrader.Upgrade(writer, request, headers)
+}
+
+// runSubtest drives the measurement pipeline shared by the download and the
+// upload subtest once the WebSocket upgrade has completed: it samples
+// tcp_info/BBR state and sends Measurement messages to the client on the
+// MinMeasurementInterval ticker, archives them under datadir, applies
+// limits.MaxDuration as a hard ceiling via the request's context, and
+// performs the closing handshake. So that download and upload cannot drift
+// apart, only |step| -- which performs one iteration of subtest-specific
+// I/O (sending data for download, receiving it for upload) and returns the
+// number of bytes it moved -- differs between the two callers.
+func runSubtest(request *http.Request, conn *websocket.Conn, limits Limits, datadir, subtest string, params subtestParams, step func(conn *websocket.Conn) (int64, error)) {
+	fd, _ := bbr.ExtractBBRFd(conn.LocalAddr().String())
+	conn.SetReadLimit(limits.MaxMessageSize)
+	defer conn.Close()
+	uuid := newUUID()
+	ip := clientIP(request.RemoteAddr)
+	results, err := newResultWriter(datadir, subtest, uuid, ip, params)
+	if err != nil {
+		log.WithError(err).Warn("newResultWriter() failed")
+	}
+	defer results.Close()
+	log.Debugf("Start running the %s subtest", subtest)
+	ctx, cancel := context.WithTimeout(request.Context(), limits.MaxDuration)
+	defer cancel()
+	ticker := time.NewTicker(MinMeasurementInterval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(params.duration)
+	defer deadline.Stop()
+
+	// step runs in its own goroutine so that the MinMeasurementInterval
+	// ticker and the limits.MaxDuration ceiling (selected on below) keep
+	// firing on their own schedule rather than at the cadence of a
+	// blocking socket read or write; stopIO tells the goroutine to give up
+	// once the subtest is over. It exits on its own shortly after, bounded
+	// by step's own per-call deadline, so there's nothing to wait for.
+	type ioResult struct {
+		n   int64
+		err error
+	}
+	ioCh := make(chan ioResult)
+	stopIO := make(chan struct{})
+	defer close(stopIO)
+	go func() {
+		sent := int64(0)
+		for sent < params.bytesBudget {
+			n, err := step(conn)
+			sent += n
+			select {
+			case ioCh <- ioResult{n, err}:
+				if err != nil {
+					return
+				}
+			case <-stopIO:
+				return
+			}
+		}
+	}()
+
+	t0 := time.Now()
+	count := int64(0)
+	var window bwWindow
+	timedOut := false
+	for running := true; running; {
+		select {
+		case <-ctx.Done():
+			log.Warn("Reached the server-side MaxDuration ceiling")
+			timedOut = true
+			running = false
+		case <-deadline.C:
+			running = false
+		case res := <-ioCh:
+			if res.err != nil {
+				log.WithError(res.err).Warnf("%s: I/O failed", subtest)
+				return
+			}
+			count += res.n
+		case t := <-ticker.C:
+			measurement := Measurement{
+				Elapsed:  t.Sub(t0).Nanoseconds(),
+				NumBytes: count,
+			}
+			if fd != -1 {
+				if tcpInfo, err := getTCPInfo(fd); err == nil {
+					measurement.TCPInfo = tcpInfo
+				}
+				bbrInfo, err := getBBRInfo(fd)
+				if err == nil {
+					bw := bbrInfo.BW
+					// Implementation note: the linux kernel header seems to suggest
+					// the measurement unit of the RTT is nsec, however, both empirical
+					// evidence and github.com/mikioh/tcpinfo [1] suggest that the RTT
+					// is actually in microseconds.
+					//
+					// [1] See https://github.com/mikioh/tcpinfo/blob/131b59fef27f73876a7760a644c1e08cf585075c/sys_linux.go#L313
+					log.Infof("BW: %f bytes/s; RTT: %f usec", bw, bbrInfo.MinRTT)
+					measurement.BBRInfo = bbrInfo
+					if params.earlyExit {
+						window.Add(bw)
+						if window.Converged(bwWindowSize) {
+							elapsed := t.Sub(t0).Nanoseconds()
+							log.Info("It seems bandwidth has converged; exiting early")
+							measurement.EarlyExit = &EarlyExitInfo{Elapsed: elapsed}
+							running = false
+						}
+					}
+				}
+			}
+			conn.SetWriteDeadline(time.Now().Add(defaultTimeout))
+			if err := conn.WriteJSON(&measurement); err != nil {
+				log.WithError(err).Warn("Cannot send measurement message")
+				return
+			}
+			results.WriteMeasurement(uuid, subtest, ip, measurement)
+		}
+	}
+	log.Debugf("Closing the %s subtest's WebSocket connection", subtest)
+	if timedOut {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(
+			websocket.CloseGoingAway, "server-side MaxDuration ceiling reached"),
+			time.Now().Add(defaultTimeout))
+		return
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(
+		websocket.CloseNormalClosure, ""), time.Now().Add(defaultTimeout))
+}
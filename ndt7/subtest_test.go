@@ -0,0 +1,126 @@
+package ndt7
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseSubtestParams(t *testing.T) {
+	tests := []struct {
+		name            string
+		query           string
+		limits          Limits
+		wantOK          bool
+		wantDuration    time.Duration
+		wantBytesBudget int64
+		wantEarlyExit   bool
+	}{
+		{
+			name:            "defaults when nothing is set",
+			query:           "",
+			limits:          Limits{MaxBytes: math.MaxInt64},
+			wantOK:          true,
+			wantDuration:    defaultDuration,
+			wantBytesBudget: math.MaxInt64,
+		},
+		{
+			name:   "negative duration is rejected",
+			query:  "duration=-1",
+			limits: Limits{MaxBytes: math.MaxInt64},
+			wantOK: false,
+		},
+		{
+			name:   "duration above maxDuration is rejected",
+			query:  "duration=31",
+			limits: Limits{MaxBytes: math.MaxInt64},
+			wantOK: false,
+		},
+		{
+			name:   "non-numeric duration is rejected",
+			query:  "duration=abc",
+			limits: Limits{MaxBytes: math.MaxInt64},
+			wantOK: false,
+		},
+		{
+			name:            "valid duration is honored",
+			query:           "duration=5",
+			limits:          Limits{MaxBytes: math.MaxInt64},
+			wantOK:          true,
+			wantDuration:    5 * time.Second,
+			wantBytesBudget: math.MaxInt64,
+		},
+		{
+			name:   "negative bytes is rejected",
+			query:  "bytes=-1",
+			limits: Limits{MaxBytes: math.MaxInt64},
+			wantOK: false,
+		},
+		{
+			name:   "bytes above maxBytes is rejected",
+			query:  "bytes=8589934593", // 8 GiB + 1
+			limits: Limits{MaxBytes: math.MaxInt64},
+			wantOK: false,
+		},
+		{
+			name:            "valid bytes under the server ceiling is honored",
+			query:           "bytes=1000",
+			limits:          Limits{MaxBytes: 10000},
+			wantOK:          true,
+			wantDuration:    defaultDuration,
+			wantBytesBudget: 1000,
+		},
+		{
+			name:            "bytes above the server ceiling is clamped",
+			query:           "bytes=9000",
+			limits:          Limits{MaxBytes: 1000},
+			wantOK:          true,
+			wantDuration:    defaultDuration,
+			wantBytesBudget: 1000,
+		},
+		{
+			name:            "early_exit=1 is honored",
+			query:           "early_exit=1",
+			limits:          Limits{MaxBytes: math.MaxInt64},
+			wantOK:          true,
+			wantDuration:    defaultDuration,
+			wantBytesBudget: math.MaxInt64,
+			wantEarlyExit:   true,
+		},
+		{
+			name:            "early_exit is off by default",
+			query:           "",
+			limits:          Limits{MaxBytes: math.MaxInt64},
+			wantOK:          true,
+			wantDuration:    defaultDuration,
+			wantBytesBudget: math.MaxInt64,
+			wantEarlyExit:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := httptest.NewRecorder()
+			request := httptest.NewRequest("GET", "/ndt/v7/download?"+tt.query, nil)
+			params, ok := parseSubtestParams(writer, request, tt.limits)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				if writer.Code != 400 {
+					t.Errorf("status = %d, want 400", writer.Code)
+				}
+				return
+			}
+			if params.duration != tt.wantDuration {
+				t.Errorf("duration = %v, want %v", params.duration, tt.wantDuration)
+			}
+			if params.bytesBudget != tt.wantBytesBudget {
+				t.Errorf("bytesBudget = %v, want %v", params.bytesBudget, tt.wantBytesBudget)
+			}
+			if params.earlyExit != tt.wantEarlyExit {
+				t.Errorf("earlyExit = %v, want %v", params.earlyExit, tt.wantEarlyExit)
+			}
+		})
+	}
+}
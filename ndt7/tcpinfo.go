@@ -0,0 +1,26 @@
+package ndt7
+
+// TCPInfo is a subset of Linux's struct tcp_info (see tcp(7)) sampled once
+// per measurement tick, so that the full bandwidth curve of a subtest --
+// not just its final number -- can be reconstructed after the fact.
+type TCPInfo struct {
+	RTT          uint32 // Smoothed round-trip time, in microseconds
+	RTTVar       uint32 // Round-trip time variance, in microseconds
+	Retransmits  uint32 // Number of retransmitted segments
+	SndCwnd      uint32 // Sender's congestion window, in segments
+	Delivered    uint32 // Total segments delivered
+	BytesAcked   uint64 // Total bytes acked
+	BytesRetrans uint64 // Total bytes retransmitted
+}
+
+// BBRInfo is the subset of the kernel's BBR congestion-control state (see
+// TCP_CC_INFO in tcp(7)) sampled once per measurement tick, alongside
+// TCPInfo.
+type BBRInfo struct {
+	BW           float64 // Max-filtered estimated bottleneck bandwidth, in bytes/s
+	MinRTT       float64 // Min-filtered round-trip time, in microseconds
+	PacingGain   float64 // Current pacing gain
+	CWndGain     float64 // Current cwnd gain
+	PacingRate   float64 // Current pacing rate, in bytes/s
+	DeliveryRate float64 // Most recent delivery-rate sample, in bytes/s
+}
@@ -0,0 +1,82 @@
+// +build linux
+
+package ndt7
+
+import (
+	"unsafe"
+
+	"github.com/m-lab/ndt-cloud/bbr"
+	"golang.org/x/sys/unix"
+)
+
+// tcpBBRInfo mirrors the kernel's struct tcp_bbr_info (see tcp(7) and
+// include/uapi/linux/tcp.h), as returned by getsockopt(TCP_CC_INFO) when the
+// socket's congestion control is BBR. bbr.GetBBRInfo only exposes bandwidth
+// and min-RTT from this struct, so the remaining fields are decoded here
+// directly rather than by extending that external package.
+type tcpBBRInfo struct {
+	BWLo       uint32
+	BWHi       uint32
+	MinRTT     uint32
+	PacingGain uint32
+	CWndGain   uint32
+}
+
+// getsockoptTCPBBRInfo issues getsockopt(fd, IPPROTO_TCP, TCP_CC_INFO, ...)
+// and decodes the result as a tcpBBRInfo.
+func getsockoptTCPBBRInfo(fd int) (*tcpBBRInfo, error) {
+	var info tcpBBRInfo
+	size := uint32(unsafe.Sizeof(info))
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd),
+		uintptr(unix.IPPROTO_TCP), uintptr(unix.TCP_CC_INFO),
+		uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	return &info, nil
+}
+
+// getTCPInfo retrieves Linux's struct tcp_info for the socket referenced by
+// fd via getsockopt(IPPROTO_TCP, TCP_INFO), so that Measurement messages can
+// carry the same retransmission and congestion-window data operators
+// already get from `ss -i`.
+func getTCPInfo(fd int) (*TCPInfo, error) {
+	info, err := unix.GetsockoptTCPInfo(fd, unix.IPPROTO_TCP, unix.TCP_INFO)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPInfo{
+		RTT:          info.Rtt,
+		RTTVar:       info.Rttvar,
+		Retransmits:  info.Total_retrans,
+		SndCwnd:      info.Snd_cwnd,
+		Delivered:    info.Delivered,
+		BytesAcked:   info.Bytes_acked,
+		BytesRetrans: info.Bytes_retrans,
+	}, nil
+}
+
+// getBBRInfo samples the kernel's BBR congestion-control state for the
+// socket referenced by fd. BW and MinRTT come from bbr.GetBBRInfo;
+// PacingGain and CWndGain come from decoding getsockopt(TCP_CC_INFO)'s
+// struct tcp_bbr_info directly; PacingRate and DeliveryRate come from
+// struct tcp_info's tcpi_pacing_rate and tcpi_delivery_rate fields.
+func getBBRInfo(fd int) (*BBRInfo, error) {
+	bw, minRTT, err := bbr.GetBBRInfo(fd)
+	if err != nil {
+		return nil, err
+	}
+	result := &BBRInfo{
+		BW:     bw,
+		MinRTT: minRTT,
+	}
+	if raw, err := getsockoptTCPBBRInfo(fd); err == nil {
+		result.PacingGain = float64(raw.PacingGain) / 256.0
+		result.CWndGain = float64(raw.CWndGain) / 256.0
+	}
+	if info, err := unix.GetsockoptTCPInfo(fd, unix.IPPROTO_TCP, unix.TCP_INFO); err == nil {
+		result.PacingRate = float64(info.Pacing_rate)
+		result.DeliveryRate = float64(info.Delivery_rate)
+	}
+	return result, nil
+}
@@ -0,0 +1,18 @@
+// +build !linux
+
+package ndt7
+
+import "errors"
+
+// errTCPInfoUnsupported is returned by getTCPInfo and getBBRInfo on
+// platforms other than Linux, which is the only OS exposing tcp_info and
+// BBR state via getsockopt.
+var errTCPInfoUnsupported = errors.New("ndt7: tcp_info/BBR sampling is not supported on this platform")
+
+func getTCPInfo(fd int) (*TCPInfo, error) {
+	return nil, errTCPInfoUnsupported
+}
+
+func getBBRInfo(fd int) (*BBRInfo, error) {
+	return nil, errTCPInfoUnsupported
+}
@@ -0,0 +1,52 @@
+package ndt7
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/gorilla/websocket"
+)
+
+// UploadHandler handles an upload subtest from the server side.
+type UploadHandler struct {
+	Upgrader websocket.Upgrader
+
+	// Limits bounds the resources this handler's subtests may consume on
+	// the server. The zero value means defaultLimits is used.
+	Limits Limits
+
+	// Datadir, when non-empty, is the directory in which a JSON-lines file
+	// recording every Measurement sent during a subtest is written, named
+	// after the subtest's UUID. An empty Datadir disables this archival.
+	Datadir string
+}
+
+// ServeHTTP handles the upload subtest: it reads binary WebSocket frames
+// from the client until duration or bytes is reached, computing goodput
+// from the number of bytes received and the elapsed time, while
+// interleaving the same Measurement messages the download subtest sends.
+func (ul UploadHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	limits := applyDefaultLimits(ul.Limits)
+	params, ok := parseSubtestParams(writer, request, limits)
+	if !ok {
+		return
+	}
+	log.Debug("Upgrading to WebSockets")
+	conn, err := upgrade(writer, request, ul.Upgrader)
+	if err != nil {
+		if err != errMissingSubprotocol {
+			log.WithError(err).Warn("upgrader.Upgrade() failed")
+		}
+		return
+	}
+	step := func(conn *websocket.Conn) (int64, error) {
+		conn.SetReadDeadline(time.Now().Add(defaultTimeout))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		return int64(len(data)), nil
+	}
+	runSubtest(request, conn, limits, ul.Datadir, "upload", params, step)
+}
@@ -0,0 +1,17 @@
+package ndt7
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// newUUID returns a random identifier used to name a subtest's on-disk
+// result file and to tag every record written to it. The Measurement
+// messages sent to the client do not carry it.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}